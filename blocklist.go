@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// stringSliceFlag implements flag.Value so --blocked-uri-list-url can be
+// passed multiple times on the command line.
+type stringSliceFlag []string
+
+func (f *stringSliceFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringSliceFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+var blockedURIListURLs stringSliceFlag
+
+func init() {
+	flag.Var(&blockedURIListURLs, "blocked-uri-list-url", "HTTPS URL of a newline-delimited blocked-uri filter list to merge into the ignore list (repeatable)")
+}
+
+// blocklistSource polls a single remote filter list over HTTPS,
+// re-fetching its body only when the server's ETag or Last-Modified
+// indicate it has changed.
+type blocklistSource struct {
+	url          string
+	client       *http.Client
+	etag         string
+	lastModified string
+	entries      []string
+}
+
+func newBlocklistSource(url string) *blocklistSource {
+	return &blocklistSource{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// fetch polls the source once, reporting whether its entries changed.
+func (s *blocklistSource) fetch() (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return false, err
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %d fetching blocklist", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	s.entries = trimEmptyAndComments(strings.Split(string(body), "\n"))
+
+	return true, nil
+}
+
+// startBlocklistReloader fetches every configured URL once, then
+// re-polls on interval, atomically swapping target so validateViolation
+// observes updates without taking a lock or requiring a restart.
+func startBlocklistReloader(urls []string, interval time.Duration, base []string, target *atomic.Pointer[[]string]) {
+	if len(urls) == 0 {
+		return
+	}
+
+	sources := make([]*blocklistSource, len(urls))
+	for i, u := range urls {
+		sources[i] = newBlocklistSource(u)
+	}
+
+	reload := func() {
+		merged := append([]string{}, base...)
+		changed := target.Load() == nil
+		for _, s := range sources {
+			didChange, err := s.fetch()
+			if err != nil {
+				log.WithError(err).WithField("url", s.url).Warn("failed to reload blocklist")
+				continue
+			}
+			changed = changed || didChange
+			merged = append(merged, s.entries...)
+		}
+
+		if !changed {
+			return
+		}
+
+		target.Store(&merged)
+		blocklistSize.Set(float64(len(merged)))
+		log.WithField("size", len(merged)).Info("reloaded blocklist")
+	}
+
+	reload()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			reload()
+		}
+	}()
+}
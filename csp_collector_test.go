@@ -8,8 +8,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	dto "github.com/prometheus/client_model/go"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -312,6 +315,297 @@ func TestLogsPath(t *testing.T) {
 	}
 }
 
+func TestHandleReportingAPIBatch(t *testing.T) {
+	var logBuffer bytes.Buffer
+	log.SetOutput(&logBuffer)
+
+	batch := `[
+		{
+			"type": "csp-violation",
+			"age": 10,
+			"url": "https://example.com/",
+			"user_agent": "test-agent",
+			"body": {
+				"documentURL": "https://example.com/",
+				"referrer": "https://example.com/referrer",
+				"blockedURL": "https://evil.example.com/",
+				"effectiveDirective": "script-src",
+				"originalPolicy": "script-src 'self'",
+				"disposition": "enforce",
+				"statusCode": 200,
+				"sample": "",
+				"sourceFile": "",
+				"lineNumber": 0,
+				"columnNumber": 0
+			}
+		}
+	]`
+
+	request, err := http.NewRequest("POST", "/", strings.NewReader(batch))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/reports+json")
+
+	recorder := httptest.NewRecorder()
+	defaultViolationReportHandler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected HTTP status %v; got %v", http.StatusOK, response.StatusCode)
+	}
+
+	logged := logBuffer.String()
+	if !strings.Contains(logged, `blocked-uri="https://evil.example.com/"`) {
+		t.Fatalf("expected batch entry to be logged as a violation in '%s'", logged)
+	}
+}
+
+func TestHandleReportingAPIBatchRespectsReportTypes(t *testing.T) {
+	var logBuffer bytes.Buffer
+	log.SetOutput(&logBuffer)
+
+	batch := `[{"type": "deprecation", "age": 0, "url": "https://example.com/", "body": {}}]`
+
+	request, err := http.NewRequest("POST", "/", strings.NewReader(batch))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/reports+json")
+
+	handler := defaultViolationReportHandler
+	handler.reportTypes = []string{"csp-violation"}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected HTTP status %v; got %v", http.StatusOK, response.StatusCode)
+	}
+
+	if logBuffer.Len() != 0 {
+		t.Fatalf("expected filtered report type not to be logged, got '%s'", logBuffer.String())
+	}
+}
+
+func TestHandlerRateLimitReturns429(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	csp := CSPReport{
+		CSPReportBody{
+			DocumentURI: "http://example.com",
+			BlockedURI:  "http://example.com",
+		},
+	}
+	payload, _ := json.Marshal(csp)
+
+	handler := defaultViolationReportHandler
+	handler.limiter = newIPRateLimiter(1, 1, 10)
+
+	newRequest := func() *http.Request {
+		request, err := http.NewRequest("POST", "/", bytes.NewBuffer(payload))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		request.RemoteAddr = "203.0.113.1:1234"
+		return request
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newRequest())
+	if status := recorder.Result().StatusCode; status != http.StatusOK {
+		t.Fatalf("expected first request to succeed with %v; got %v", http.StatusOK, status)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newRequest())
+	if status := recorder.Result().StatusCode; status != http.StatusTooManyRequests {
+		t.Errorf("expected second request to be rate limited with %v; got %v", http.StatusTooManyRequests, status)
+	}
+}
+
+func TestHandlerMaxBodyBytesReturns413(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	csp := CSPReport{
+		CSPReportBody{
+			DocumentURI: "http://example.com",
+			BlockedURI:  "http://example.com",
+		},
+	}
+	payload, _ := json.Marshal(csp)
+
+	handler := defaultViolationReportHandler
+	handler.maxBodyBytes = 1
+
+	request, err := http.NewRequest("POST", "/", bytes.NewBuffer(payload))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected HTTP status %v; got %v", http.StatusRequestEntityTooLarge, response.StatusCode)
+	}
+}
+
+func TestClientIPIgnoresUntrustedXFF(t *testing.T) {
+	trustedProxies = nil
+
+	request, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	request.RemoteAddr = "203.0.113.1:1234"
+	request.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+	if ip := clientIP(request); ip != "203.0.113.1" {
+		t.Errorf("expected untrusted X-Forwarded-For to be ignored, got %q", ip)
+	}
+}
+
+func TestClientIPHonorsTrustedProxyXFF(t *testing.T) {
+	trustedProxies = stringSliceFlag{"203.0.113.0/24"}
+	defer func() { trustedProxies = nil }()
+
+	request, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	request.RemoteAddr = "203.0.113.1:1234"
+	request.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+	if ip := clientIP(request); ip != "10.0.0.1" {
+		t.Errorf("expected X-Forwarded-For from a trusted proxy to be honored, got %q", ip)
+	}
+}
+
+func TestBlocklistReloadObservedByValidateViolation(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "evil.example.com\n")
+	}))
+	defer server.Close()
+
+	var pointer atomic.Pointer[[]string]
+	startBlocklistReloader([]string{server.URL}, time.Hour, nil, &pointer)
+
+	handler := defaultViolationReportHandler
+	handler.blockedURIsPointer = &pointer
+
+	report := CSPReport{Body: CSPReportBody{
+		DocumentURI: "https://example.com",
+		BlockedURI:  "https://evil.example.com/script.js",
+	}}
+
+	if err := handler.validateViolation(report); err == nil {
+		t.Fatalf("expected blocklist entry fetched from the remote source to reject the report")
+	}
+
+	unaffected := CSPReport{Body: CSPReportBody{
+		DocumentURI: "https://example.com",
+		BlockedURI:  "https://safe.example.com/script.js",
+	}}
+	if err := handler.validateViolation(unaffected); err != nil {
+		t.Errorf("expected unrelated blocked-uri not to be rejected, got %v", err)
+	}
+}
+
+func TestSanitizeEffectiveDirective(t *testing.T) {
+	cases := []struct {
+		directive string
+		expected  string
+	}{
+		{"script-src", "script-src"},
+		{"frame-ancestors", "frame-ancestors"},
+		{"totally-made-up-directive", "other"},
+		{"", "other"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.directive, func(t *testing.T) {
+			if actual := sanitizeEffectiveDirective(tc.directive); actual != tc.expected {
+				t.Errorf("sanitizing %q yielded %q, expected %q", tc.directive, actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSanitizeDisposition(t *testing.T) {
+	cases := []struct {
+		disposition string
+		expected    string
+	}{
+		{"enforce", "enforce"},
+		{"report", "report"},
+		{"a-fresh-random-value", "other"},
+		{"", "other"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.disposition, func(t *testing.T) {
+			if actual := sanitizeDisposition(tc.disposition); actual != tc.expected {
+				t.Errorf("sanitizing %q yielded %q, expected %q", tc.disposition, actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestHandlerRejectsUnboundedMetricCardinality(t *testing.T) {
+	log.SetOutput(io.Discard)
+
+	readOtherCounter := func() float64 {
+		metric, err := reportsReceivedTotal.GetMetricWithLabelValues("other", "other")
+		if err != nil {
+			t.Fatalf("failed to fetch metric: %v", err)
+		}
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatalf("failed to read metric: %v", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	before := readOtherCounter()
+
+	for i := 0; i < 5; i++ {
+		csp := CSPReport{
+			CSPReportBody{
+				DocumentURI:        "https://example.com",
+				BlockedURI:         "https://example.com",
+				EffectiveDirective: fmt.Sprintf("attacker-directive-%d", i),
+				Disposition:        fmt.Sprintf("attacker-disposition-%d", i),
+			},
+		}
+		payload, _ := json.Marshal(csp)
+
+		request, err := http.NewRequest("POST", "/", bytes.NewBuffer(payload))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		recorder := httptest.NewRecorder()
+		defaultViolationReportHandler.ServeHTTP(recorder, request)
+	}
+
+	if got := readOtherCounter() - before; got != 5 {
+		t.Errorf("expected 5 attacker-chosen directive/disposition values to collapse onto the \"other\" label, got %v", got)
+	}
+}
+
 func TestTruncateQueryStringFragment(t *testing.T) {
 	t.Parallel()
 
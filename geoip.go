@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/mssola/user_agent"
+	"github.com/oschwald/geoip2-golang"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	geoIPDBPath = flag.String("geoip-db", "", "Path to a MaxMind GeoLite2-City MMDB for country enrichment (disabled if unset)")
+	asnDBPath   = flag.String("asn-db", "", "Path to a MaxMind GeoLite2-ASN MMDB for ASN enrichment (disabled if unset)")
+)
+
+// geoEnricher resolves GeoIP fields for a client IP from hot-reloadable
+// MaxMind MMDB readers. It is a no-op when no database paths are set.
+type geoEnricher struct {
+	cityPath string
+	asnPath  string
+	city     atomic.Pointer[geoip2.Reader]
+	asn      atomic.Pointer[geoip2.Reader]
+}
+
+// newGeoEnricher loads the configured MMDBs once and, if at least one
+// path was given, starts a SIGHUP handler that hot-reloads them.
+func newGeoEnricher(cityPath, asnPath string) *geoEnricher {
+	e := &geoEnricher{cityPath: cityPath, asnPath: asnPath}
+	e.reload()
+
+	if cityPath != "" || asnPath != "" {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		go func() {
+			for range sighup {
+				log.Info("reloading GeoIP databases")
+				e.reload()
+			}
+		}()
+	}
+
+	return e
+}
+
+func (e *geoEnricher) reload() {
+	if e.cityPath != "" {
+		if r, err := geoip2.Open(e.cityPath); err != nil {
+			log.WithError(err).WithField("path", e.cityPath).Warn("failed to load GeoIP city database")
+		} else if old := e.city.Swap(r); old != nil {
+			old.Close()
+		}
+	}
+
+	if e.asnPath != "" {
+		if r, err := geoip2.Open(e.asnPath); err != nil {
+			log.WithError(err).WithField("path", e.asnPath).Warn("failed to load GeoIP ASN database")
+		} else if old := e.asn.Swap(r); old != nil {
+			old.Close()
+		}
+	}
+}
+
+// enrich adds geo_country/geo_asn fields to fields for ip, leaving
+// fields untouched for any database that isn't loaded or can't resolve
+// the address.
+func (e *geoEnricher) enrich(fields log.Fields, ip string) {
+	if e == nil {
+		return
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return
+	}
+
+	if reader := e.city.Load(); reader != nil {
+		if record, err := reader.City(parsed); err == nil {
+			fields["geo_country"] = record.Country.IsoCode
+		}
+	}
+
+	if reader := e.asn.Load(); reader != nil {
+		if record, err := reader.ASN(parsed); err == nil {
+			fields["geo_asn"] = record.AutonomousSystemNumber
+		}
+	}
+}
+
+// enrichUserAgent adds ua_browser/ua_os/ua_device fields parsed from
+// the request's User-Agent header.
+func enrichUserAgent(fields log.Fields, r *http.Request) {
+	header := r.Header.Get("User-Agent")
+	if header == "" {
+		return
+	}
+
+	ua := user_agent.New(header)
+	name, version := ua.Browser()
+
+	fields["ua_browser"] = name
+	fields["ua_browser_version"] = version
+	fields["ua_os"] = ua.OS()
+	fields["ua_device"] = ua.Platform()
+}
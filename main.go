@@ -2,37 +2,284 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// CSPReport is the top-level object sent by browsers implementing the
+// CSP Level 2 report-uri directive.
 type CSPReport struct {
-	Body struct {
-		DocumentURI        string `json:"document-uri"`
-		Referrer           string `json:"referrer"`
-		BlockedURI         string `json:"blocked-uri"`
-		ViolatedDirective  string `json:"violated-directive"`
-		EffectiveDirective string `json:"effective-directive"`
-		OriginalPolicy     string `json:"original-policy"`
-	} `json:"csp-report"`
+	Body CSPReportBody `json:"csp-report"`
 }
 
-func main() {
-	http.HandleFunc("/", handleViolationReport)
-	http.ListenAndServe(":80", nil)
+// CSPReportBody holds the fields nested under the "csp-report" key.
+type CSPReportBody struct {
+	DocumentURI        string      `json:"document-uri"`
+	Referrer           string      `json:"referrer"`
+	BlockedURI         string      `json:"blocked-uri"`
+	ViolatedDirective  string      `json:"violated-directive"`
+	EffectiveDirective string      `json:"effective-directive"`
+	OriginalPolicy     string      `json:"original-policy"`
+	Disposition        string      `json:"disposition"`
+	StatusCode         interface{} `json:"status-code"`
+	ScriptSample       string      `json:"script-sample"`
+	SourceFile         string      `json:"source-file"`
+	LineNumber         interface{} `json:"line-number"`
+	ColumnNumber       interface{} `json:"column-number"`
+}
+
+// defaultIgnoredBlockedURIs lists blocked-uri values known to come from
+// browser extensions and other non-actionable sources rather than real
+// policy violations.
+var defaultIgnoredBlockedURIs = []string{
+	"resource://",
+	"chromenull://",
+	"chrome-extension://",
+	"safari-extension://",
+	"mxjscall://",
+	"webviewprogressproxy://",
+	"res://",
+	"mx://",
+	"safari-resource://",
+	"chromeinvoke://",
+	"chromeinvokeimmediate://",
+	"mbinit://",
+	"opera://",
+	"localhost",
+	"127.0.0.1",
+	"none://",
+	"about:blank",
+	"android-webview",
+	"ms-browser-extension",
+	"wvjbscheme://__wvjb_queue_message__",
+	"nativebaiduhd://adblock",
+	"bdvideo://error",
+}
+
+// violationReportHandler decodes and logs CSP violation reports posted
+// to it, optionally forwarding them to one or more output sinks.
+type violationReportHandler struct {
+	blockedURIs                 []string
+	blockedURIsPointer          *atomic.Pointer[[]string]
+	truncateQueryStringFragment bool
+	metadataObject              bool
+	dispatcher                  *sinkDispatcher
+	reportTypes                 []string
+	maxBodyBytes                int64
+	limiter                     *ipRateLimiter
+	geo                         *geoEnricher
+}
+
+// currentBlockedURIs returns the live, reloadable blocklist if one has
+// been configured via --blocked-uri-list-url, otherwise the static list
+// the handler was constructed with.
+func (h violationReportHandler) currentBlockedURIs() []string {
+	if h.blockedURIsPointer != nil {
+		if p := h.blockedURIsPointer.Load(); p != nil {
+			return *p
+		}
+	}
+	return h.blockedURIs
 }
-func handleViolationReport(w http.ResponseWriter, r *http.Request) {
+
+func (h violationReportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
+	if h.limiter != nil && !h.limiter.allow(clientIP(r)) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	if h.maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
+	}
+
+	if isReportingAPIContentType(r.Header.Get("Content-Type")) {
+		h.handleReportingAPIBatch(w, r)
+		return
+	}
+
 	decoder := json.NewDecoder(r.Body)
 	var report CSPReport
 
+	start := time.Now()
 	err := decoder.Decode(&report)
+	timeDecode(start)
 	if err != nil {
-		panic(err)
+		reportsDroppedTotal.WithLabelValues("decode_error").Inc()
+		writeDecodeError(w, err)
+		return
 	}
 	defer r.Body.Close()
 
+	h.logViolation(r, report)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// logViolation validates report, and if it passes, logs it and forwards
+// it to any configured output sinks.
+func (h violationReportHandler) logViolation(r *http.Request, report CSPReport) {
+	if err := h.validateViolation(report); err != nil {
+		reason := "invalid_blocked_uri"
+		if strings.Contains(err.Error(), "document URI") {
+			reason = "invalid_document_uri"
+		}
+		reportsDroppedTotal.WithLabelValues(reason).Inc()
+		log.Debug(err)
+		return
+	}
+
+	reportsReceivedTotal.WithLabelValues(sanitizeEffectiveDirective(report.Body.EffectiveDirective), sanitizeDisposition(report.Body.Disposition)).Inc()
+
+	if h.truncateQueryStringFragment {
+		report.Body.DocumentURI = truncateQueryStringFragment(report.Body.DocumentURI)
+		report.Body.BlockedURI = truncateQueryStringFragment(report.Body.BlockedURI)
+	}
+
+	fields := log.Fields{
+		"document-uri":        report.Body.DocumentURI,
+		"referrer":            report.Body.Referrer,
+		"blocked-uri":         report.Body.BlockedURI,
+		"violated-directive":  report.Body.ViolatedDirective,
+		"effective-directive": report.Body.EffectiveDirective,
+		"original-policy":     report.Body.OriginalPolicy,
+		"disposition":         report.Body.Disposition,
+		"status-code":         report.Body.StatusCode,
+		"script-sample":       report.Body.ScriptSample,
+		"source-file":         report.Body.SourceFile,
+		"line-number":         report.Body.LineNumber,
+		"column-number":       report.Body.ColumnNumber,
+		"path":                r.URL.Path,
+	}
+
+	query := r.URL.Query()
+	if len(query) > 0 {
+		if h.metadataObject {
+			metadata := make(map[string]string, len(query))
+			for key, values := range query {
+				metadata[key] = values[0]
+			}
+			fields["metadata"] = metadata
+		} else {
+			for _, values := range query {
+				fields["metadata"] = values[0]
+			}
+		}
+	}
+
+	h.geo.enrich(fields, clientIP(r))
+	enrichUserAgent(fields, r)
+
+	log.WithFields(fields).Info("CSP Violation")
+
+	if h.dispatcher != nil {
+		h.dispatcher.dispatch(r.Context(), report, fields)
+	}
+}
+
+// validateViolation returns an error if the report should not be logged,
+// e.g. because its blocked-uri is a known non-actionable resource or its
+// document-uri isn't an http(s) URL.
+func (h violationReportHandler) validateViolation(report CSPReport) error {
+	if !strings.HasPrefix(report.Body.DocumentURI, "http://") && !strings.HasPrefix(report.Body.DocumentURI, "https://") {
+		return fmt.Errorf("document URI ('%s') is invalid", report.Body.DocumentURI)
+	}
+
+	for _, blockedURI := range h.currentBlockedURIs() {
+		if strings.Contains(report.Body.BlockedURI, blockedURI) {
+			return fmt.Errorf("blocked URI ('%s') is an invalid resource", report.Body.BlockedURI)
+		}
+	}
+
+	return nil
+}
+
+// trimEmptyAndComments strips blank lines and "#"-prefixed comments from
+// a filter list read from disk.
+func trimEmptyAndComments(lines []string) []string {
+	trimmed := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		trimmed = append(trimmed, line)
+	}
+	return trimmed
+}
+
+// truncateQueryStringFragment removes any query string and fragment from
+// a URL, keeping only the scheme, host and path.
+func truncateQueryStringFragment(u string) string {
+	if i := strings.IndexAny(u, "?#"); i != -1 {
+		return u[:i]
+	}
+	return u
+}
+
+func main() {
+	listenAddress := flag.String("listen-address", ":8080", "Address and port to listen on")
+	truncateQSF := flag.Bool("truncate-query-string-fragment", false, "Truncate query strings and fragments from document and blocked URIs before logging")
+	metadataObject := flag.Bool("metadata-object", false, "Log the full query string as a single metadata object instead of a single value")
+	outputFlag := flag.String("output", "stdout", "Comma-separated list of output sinks to write violation reports to (stdout,elasticsearch,loki,kafka,syslog)")
+	dropOnFull := flag.Bool("drop-on-full", false, "Drop violation reports instead of blocking the HTTP handler when a sink's buffer is full")
+	reportTypesFlag := flag.String("report-types", "", "Comma-separated list of Reporting API report types to process (default: all types)")
+	metricsAddress := flag.String("metrics-addr", ":9090", "Address and port for the Prometheus /metrics listener")
+	metricsPath := flag.String("metrics-path", "/metrics", "Path to expose Prometheus metrics on")
+	maxBodyBytes := flag.Int64("max-body-bytes", 65536, "Maximum accepted size of a violation report request body, in bytes (0 disables the limit)")
+	rateLimitFlag := flag.String("rate-limit", "", "Per-client rate limit as <requests>/s, e.g. 100/s (disabled if unset)")
+	rateLimitBurst := flag.Int("rate-limit-burst", 20, "Burst size for --rate-limit")
+	rateLimitCacheSize := flag.Int("rate-limit-cache-size", 10000, "Maximum number of per-client rate limiter entries to keep")
+	blocklistRefresh := flag.Duration("blocklist-refresh", 5*time.Minute, "How often to re-poll --blocked-uri-list-url sources for changes")
+	flag.Parse()
+
+	geo := newGeoEnricher(*geoIPDBPath, *asnDBPath)
+
+	serveMetrics(*metricsAddress, *metricsPath)
+
+	var limiter *ipRateLimiter
+	if *rateLimitFlag != "" {
+		limit, err := parseRateLimit(*rateLimitFlag)
+		if err != nil {
+			log.WithError(err).Fatal("invalid --rate-limit")
+		}
+		limiter = newIPRateLimiter(limit, *rateLimitBurst, *rateLimitCacheSize)
+	}
+
+	dispatcher, err := newSinkDispatcher(*outputFlag, *dropOnFull)
+	if err != nil {
+		log.WithError(err).Fatal("failed to configure output sinks")
+	}
+	defer dispatcher.close()
+
+	handler := violationReportHandler{
+		blockedURIs:                 defaultIgnoredBlockedURIs,
+		truncateQueryStringFragment: *truncateQSF,
+		metadataObject:              *metadataObject,
+		dispatcher:                  dispatcher,
+		reportTypes:                 trimEmptyAndComments(strings.Split(*reportTypesFlag, ",")),
+		maxBodyBytes:                *maxBodyBytes,
+		limiter:                     limiter,
+		geo:                         geo,
+	}
+	blocklistSize.Set(float64(len(handler.blockedURIs)))
+
+	if len(blockedURIListURLs) > 0 {
+		var pointer atomic.Pointer[[]string]
+		startBlocklistReloader(blockedURIListURLs, *blocklistRefresh, defaultIgnoredBlockedURIs, &pointer)
+		handler.blockedURIsPointer = &pointer
+	}
+
+	http.Handle("/", handler)
+	log.WithField("address", *listenAddress).Info("starting CSP collector")
+	log.Fatal(http.ListenAndServe(*listenAddress, nil))
 }
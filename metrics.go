@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	reportsReceivedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "csp_reports_received_total",
+		Help: "Total number of CSP violation reports accepted and logged.",
+	}, []string{"effective_directive", "disposition"})
+
+	reportsDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "csp_reports_dropped_total",
+		Help: "Total number of CSP violation reports dropped before logging.",
+	}, []string{"reason"})
+
+	reportDecodeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "csp_report_decode_duration_seconds",
+		Help: "Time spent decoding a violation report request body.",
+	})
+
+	blocklistSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "csp_collector_blocklist_size",
+		Help: "Current number of entries in the blocked-uri ignore list.",
+	})
+)
+
+// serveMetrics starts a second HTTP listener exposing Prometheus
+// metrics at path, independent of the main violation report listener.
+func serveMetrics(addr, path string) {
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.Handler())
+
+	go func() {
+		log.WithFields(log.Fields{"address": addr, "path": path}).Info("starting metrics listener")
+		log.WithError(http.ListenAndServe(addr, mux)).Fatal("metrics listener exited")
+	}()
+}
+
+// timeDecode records how long a report body took to decode.
+func timeDecode(start time.Time) {
+	reportDecodeDuration.Observe(time.Since(start).Seconds())
+}
+
+// knownEffectiveDirectives allowlists the CSP directive names that can
+// appear as an effective-directive, per the CSP Level 2/3 specs.
+var knownEffectiveDirectives = map[string]bool{
+	"default-src":               true,
+	"script-src":                true,
+	"script-src-elem":           true,
+	"script-src-attr":           true,
+	"style-src":                 true,
+	"style-src-elem":            true,
+	"style-src-attr":            true,
+	"img-src":                   true,
+	"connect-src":               true,
+	"font-src":                  true,
+	"object-src":                true,
+	"media-src":                 true,
+	"frame-src":                 true,
+	"frame-ancestors":           true,
+	"child-src":                 true,
+	"worker-src":                true,
+	"manifest-src":              true,
+	"prefetch-src":              true,
+	"form-action":               true,
+	"base-uri":                  true,
+	"sandbox":                   true,
+	"plugin-types":              true,
+	"report-uri":                true,
+	"block-all-mixed-content":   true,
+	"upgrade-insecure-requests": true,
+	"require-trusted-types-for": true,
+	"trusted-types":             true,
+	"navigate-to":               true,
+}
+
+// metricLabelOther is used in place of any value outside a metric label's
+// allowlist, so an attacker posting arbitrary report bodies can't grow a
+// CounterVec's cardinality without bound.
+const metricLabelOther = "other"
+
+// sanitizeEffectiveDirective maps an arbitrary, attacker-controlled
+// effective-directive value onto the fixed set of real CSP directives, so
+// it's safe to use as a Prometheus label value.
+func sanitizeEffectiveDirective(directive string) string {
+	if knownEffectiveDirectives[directive] {
+		return directive
+	}
+	return metricLabelOther
+}
+
+// sanitizeDisposition maps an arbitrary, attacker-controlled disposition
+// value onto the two values the CSP spec defines, so it's safe to use as
+// a Prometheus label value.
+func sanitizeDisposition(disposition string) string {
+	switch disposition {
+	case "enforce", "report":
+		return disposition
+	default:
+		return metricLabelOther
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// trustedProxies lists the CIDRs of reverse proxies allowed to set
+// X-Forwarded-For. A request's header is honored only when its immediate
+// peer (RemoteAddr) matches one of these, otherwise a hostile client could
+// defeat both the rate limiter and GeoIP enrichment by sending a fresh
+// fake address on every request.
+var trustedProxies stringSliceFlag
+
+func init() {
+	flag.Var(&trustedProxies, "trusted-proxy", "CIDR of a reverse proxy allowed to set X-Forwarded-For (repeatable); the header is ignored unless the immediate peer matches one of these")
+}
+
+// isTrustedProxy reports whether remoteAddr (a host:port as seen on
+// http.Request.RemoteAddr) matches one of the configured --trusted-proxy
+// CIDRs.
+func isTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, cidr := range trustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeDecodeError responds 413 if err came from a body that exceeded
+// the configured --max-body-bytes limit, or 400 for any other decode
+// failure, logging either case instead of panicking.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		log.WithError(err).Debug("rejected oversized violation report body")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	log.WithError(err).Debug("failed to decode violation report")
+	w.WriteHeader(http.StatusBadRequest)
+}
+
+// ipRateLimiter hands out a token-bucket rate.Limiter per client IP,
+// keeping at most capacity entries by evicting the least recently used
+// one so memory can't grow unbounded under a spoofed-IP flood.
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type rateLimiterEntry struct {
+	key     string
+	limiter *rate.Limiter
+}
+
+func newIPRateLimiter(limit rate.Limit, burst, capacity int) *ipRateLimiter {
+	return &ipRateLimiter{
+		limit:    limit,
+		burst:    burst,
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// allow reports whether a request from key may proceed, creating a
+// fresh bucket for keys it hasn't seen yet.
+func (l *ipRateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		l.ll.MoveToFront(el)
+		return el.Value.(*rateLimiterEntry).limiter.Allow()
+	}
+
+	entry := &rateLimiterEntry{key: key, limiter: rate.NewLimiter(l.limit, l.burst)}
+	l.items[key] = l.ll.PushFront(entry)
+
+	if l.ll.Len() > l.capacity {
+		oldest := l.ll.Back()
+		if oldest != nil {
+			l.ll.Remove(oldest)
+			delete(l.items, oldest.Value.(*rateLimiterEntry).key)
+		}
+	}
+
+	return entry.limiter.Allow()
+}
+
+// parseRateLimit parses a "<requests>/s" spec such as "100/s" into a
+// rate.Limit.
+func parseRateLimit(spec string) (rate.Limit, error) {
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) != 2 || parts[1] != "s" {
+		return 0, fmt.Errorf("invalid rate limit %q, expected format <requests>/s", spec)
+	}
+
+	n, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate limit %q: %w", spec, err)
+	}
+
+	return rate.Limit(n), nil
+}
+
+// clientIP extracts the client address used to key the rate limiter and
+// GeoIP enrichment. X-Forwarded-For is only honored when the request's
+// immediate peer is a configured --trusted-proxy; otherwise it's trivially
+// spoofable by the client itself, which would let a hostile page defeat
+// the rate limiter by sending a fresh fake address on every request.
+func clientIP(r *http.Request) string {
+	if len(trustedProxies) > 0 && isTrustedProxy(r.RemoteAddr) {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			if i := strings.Index(xff, ","); i != -1 {
+				return strings.TrimSpace(xff[:i])
+			}
+			return strings.TrimSpace(xff)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// newTLSConfig builds a *tls.Config for a networked sink's client from its
+// --<sink>-tls-skip-verify and --<sink>-tls-ca-file flags. It returns nil
+// when neither is set, so callers can fall back to their transport's
+// default TLS behavior.
+func newTLSConfig(skipVerify bool, caFile string) (*tls.Config, error) {
+	if !skipVerify && caFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: skipVerify}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file %q: %w", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// OutputSink delivers a decoded violation report, along with the log
+// fields computed for it, to a downstream system.
+type OutputSink interface {
+	Name() string
+	Write(ctx context.Context, report CSPReport, metadata log.Fields) error
+}
+
+// mergeMetadata flattens report.Body into a JSON-able map and overlays
+// metadata on top of it, so enrichment fields (geo_country, ua_browser,
+// the --metadata-object query fields, ...) reach sinks that index a
+// single document per violation instead of being silently dropped.
+func mergeMetadata(body CSPReportBody, metadata log.Fields) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		return nil, err
+	}
+
+	for k, v := range metadata {
+		doc[k] = v
+	}
+
+	return doc, nil
+}
+
+// sinkDispatcher fans a violation report out to every configured
+// OutputSink, each over its own buffered, asynchronous queue and worker
+// goroutine, so a slow or unreachable backend can neither stall delivery
+// to the other sinks nor the HTTP handler that called dispatch.
+type sinkDispatcher struct {
+	workers    []*sinkWorker
+	dropOnFull bool
+}
+
+// sinkWorker owns one OutputSink's queue and the single goroutine that
+// drains it, retrying failed writes with backoff.
+type sinkWorker struct {
+	sink  OutputSink
+	queue chan dispatchJob
+	done  chan struct{}
+}
+
+type dispatchJob struct {
+	report   CSPReport
+	metadata log.Fields
+}
+
+const (
+	dispatchQueueSize   = 1024
+	dispatchMaxRetries  = 3
+	dispatchBaseBackoff = 200 * time.Millisecond
+
+	// dispatchEnqueueTimeout bounds how long dispatch will wait for room
+	// in a full sink queue when --drop-on-full is unset. The caller's
+	// request context isn't canceled while its own handler is still
+	// running, so waiting on it alone would block the handler forever
+	// behind one down sink.
+	dispatchEnqueueTimeout = 2 * time.Second
+)
+
+// newSinkDispatcher parses a comma-separated --output flag value (e.g.
+// "stdout,loki,kafka") and builds the corresponding sinks, each with its
+// own worker goroutine. The "stdout" sink is a no-op here since the plain
+// logrus line written by violationReportHandler.ServeHTTP already serves
+// that purpose.
+func newSinkDispatcher(outputFlag string, dropOnFull bool) (*sinkDispatcher, error) {
+	d := &sinkDispatcher{dropOnFull: dropOnFull}
+
+	var sinks []OutputSink
+	for _, name := range strings.Split(outputFlag, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "", "stdout":
+			continue
+		case "elasticsearch":
+			sink, err := newElasticsearchSinkFromFlags()
+			if err != nil {
+				return nil, fmt.Errorf("configuring elasticsearch sink: %w", err)
+			}
+			sinks = append(sinks, sink)
+		case "loki":
+			sink, err := newLokiSinkFromFlags()
+			if err != nil {
+				return nil, fmt.Errorf("configuring loki sink: %w", err)
+			}
+			sinks = append(sinks, sink)
+		case "kafka":
+			sink, err := newKafkaSinkFromFlags()
+			if err != nil {
+				return nil, fmt.Errorf("configuring kafka sink: %w", err)
+			}
+			sinks = append(sinks, sink)
+		case "syslog":
+			sink, err := newSyslogSinkFromFlags()
+			if err != nil {
+				return nil, fmt.Errorf("configuring syslog sink: %w", err)
+			}
+			sinks = append(sinks, sink)
+		default:
+			return nil, fmt.Errorf("unknown output sink %q", name)
+		}
+	}
+
+	for _, sink := range sinks {
+		w := &sinkWorker{sink: sink, queue: make(chan dispatchJob, dispatchQueueSize), done: make(chan struct{})}
+		d.workers = append(d.workers, w)
+		go w.run()
+	}
+
+	return d, nil
+}
+
+// dispatch enqueues a report for delivery to every configured sink,
+// independently of the others. If a sink's queue is full, the job is
+// either dropped immediately (--drop-on-full) or given up to
+// dispatchEnqueueTimeout to make room before being dropped.
+func (d *sinkDispatcher) dispatch(ctx context.Context, report CSPReport, metadata log.Fields) {
+	if len(d.workers) == 0 {
+		return
+	}
+
+	job := dispatchJob{report: report, metadata: metadata}
+
+	for _, w := range d.workers {
+		w.enqueue(ctx, job, d.dropOnFull)
+	}
+}
+
+func (w *sinkWorker) enqueue(ctx context.Context, job dispatchJob, dropOnFull bool) {
+	if dropOnFull {
+		select {
+		case w.queue <- job:
+		default:
+			log.WithField("sink", w.sink.Name()).Warn("output sink queue full, dropping violation report")
+		}
+		return
+	}
+
+	timer := time.NewTimer(dispatchEnqueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case w.queue <- job:
+	case <-ctx.Done():
+	case <-timer.C:
+		log.WithField("sink", w.sink.Name()).Warn("output sink queue still full after timeout, dropping violation report")
+	}
+}
+
+func (w *sinkWorker) run() {
+	for job := range w.queue {
+		w.writeWithRetry(job)
+	}
+	close(w.done)
+}
+
+func (w *sinkWorker) writeWithRetry(job dispatchJob) {
+	backoff := dispatchBaseBackoff
+	for attempt := 0; attempt <= dispatchMaxRetries; attempt++ {
+		err := w.sink.Write(context.Background(), job.report, job.metadata)
+		if err == nil {
+			return
+		}
+		log.WithError(err).WithField("sink", w.sink.Name()).Warn("failed to write violation report to output sink")
+		if attempt == dispatchMaxRetries {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// close drains every sink's queue and waits for in-flight writes to
+// finish.
+func (d *sinkDispatcher) close() {
+	if d == nil {
+		return
+	}
+	for _, w := range d.workers {
+		close(w.queue)
+	}
+	for _, w := range d.workers {
+		<-w.done
+	}
+}
+
+// elasticsearchSink bulk-indexes violation reports into Elasticsearch.
+type elasticsearchSink struct {
+	endpoint string
+	index    string
+	client   *http.Client
+}
+
+func newElasticsearchSinkFromFlags() (*elasticsearchSink, error) {
+	tlsConfig, err := newTLSConfig(*esTLSSkipVerify, *esTLSCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	if tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	return &elasticsearchSink{
+		endpoint: *esEndpoint,
+		index:    *esIndex,
+		client:   client,
+	}, nil
+}
+
+func (s *elasticsearchSink) Name() string { return "elasticsearch" }
+
+func (s *elasticsearchSink) Write(ctx context.Context, report CSPReport, metadata log.Fields) error {
+	doc, err := mergeMetadata(report.Body, metadata)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	meta := map[string]interface{}{"index": map[string]string{"_index": s.index}}
+	if err := json.NewEncoder(&buf).Encode(meta); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(&buf).Encode(doc); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.endpoint, "/")+"/_bulk", &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("elasticsearch bulk request failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// lokiSink pushes violation reports to Grafana Loki's push API.
+type lokiSink struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newLokiSinkFromFlags() (*lokiSink, error) {
+	tlsConfig, err := newTLSConfig(*lokiTLSSkipVerify, *lokiTLSCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	if tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	return &lokiSink{
+		endpoint: *lokiEndpoint,
+		client:   client,
+	}, nil
+}
+
+func (s *lokiSink) Name() string { return "loki" }
+
+func (s *lokiSink) Write(ctx context.Context, report CSPReport, metadata log.Fields) error {
+	doc, err := mergeMetadata(report.Body, metadata)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"streams": []map[string]interface{}{
+			{
+				"stream": map[string]string{"job": "go-csp-collector"},
+				"values": [][]string{{fmt.Sprintf("%d", time.Now().UnixNano()), string(line)}},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.endpoint, "/")+"/loki/api/v1/push", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("loki push request failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// kafkaSink produces each violation report as a JSON message to a Kafka
+// topic.
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+func newKafkaSinkFromFlags() (*kafkaSink, error) {
+	tlsConfig, err := newTLSConfig(*kafkaTLSSkipVerify, *kafkaTLSCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &kafka.Writer{
+		Addr:     kafka.TCP(strings.Split(*kafkaBrokers, ",")...),
+		Topic:    *kafkaTopic,
+		Balancer: &kafka.LeastBytes{},
+	}
+	if tlsConfig != nil {
+		writer.Transport = &kafka.Transport{TLS: tlsConfig}
+	}
+
+	return &kafkaSink{writer: writer}, nil
+}
+
+func (s *kafkaSink) Name() string { return "kafka" }
+
+func (s *kafkaSink) Write(ctx context.Context, report CSPReport, metadata log.Fields) error {
+	value, err := json.Marshal(report.Body)
+	if err != nil {
+		return err
+	}
+
+	headers := make([]kafka.Header, 0, len(metadata))
+	for k, v := range metadata {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(fmt.Sprint(v))})
+	}
+
+	return s.writer.WriteMessages(ctx, kafka.Message{Value: value, Headers: headers})
+}
+
+// RFC 5424 facility/severity for the syslog sink: daemon facility (3),
+// informational severity (6).
+const (
+	syslogFacilityDaemon = 3
+	syslogSeverityInfo   = 6
+	syslogPriority       = syslogFacilityDaemon*8 + syslogSeverityInfo
+)
+
+// syslogSink forwards violation reports as RFC 5424 syslog messages.
+type syslogSink struct {
+	network   string
+	addr      string
+	tlsConfig *tls.Config
+}
+
+func newSyslogSinkFromFlags() (*syslogSink, error) {
+	tlsConfig, err := newTLSConfig(*syslogTLSSkipVerify, *syslogTLSCAFile)
+	if err != nil {
+		return nil, err
+	}
+	if *syslogNetwork == "tcp-tls" && tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+
+	return &syslogSink{network: *syslogNetwork, addr: *syslogAddr, tlsConfig: tlsConfig}, nil
+}
+
+func (s *syslogSink) Name() string { return "syslog" }
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	if s.network == "tcp-tls" {
+		return tls.Dial("tcp", s.addr, s.tlsConfig)
+	}
+	return net.Dial(s.network, s.addr)
+}
+
+func (s *syslogSink) Write(ctx context.Context, report CSPReport, metadata log.Fields) error {
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Hand-rolled RFC 5424 header: <PRI>VERSION TIMESTAMP HOSTNAME
+	// APP-NAME PROCID MSGID STRUCTURED-DATA MSG. There's no off-the-shelf
+	// client formatter in our dependency set, so we build the line
+	// ourselves rather than pull in a server-side parsing library.
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - %s %s\n",
+		syslogPriority,
+		time.Now().UTC().Format(time.RFC3339),
+		"go-csp-collector",
+		"go-csp-collector",
+		os.Getpid(),
+		syslogStructuredData(metadata),
+		mustJSON(report.Body),
+	)
+
+	_, err = conn.Write([]byte(msg))
+	return err
+}
+
+// syslogSDIDReplacer sanitizes a log.Fields key into a valid RFC 5424
+// PARAM-NAME: no space, '=', ']' or '"'.
+var syslogSDIDReplacer = strings.NewReplacer(" ", "_", "=", "_", "]", "_", `"`, "_")
+
+// syslogSDValueReplacer escapes the characters RFC 5424 requires escaping
+// inside a quoted PARAM-VALUE.
+var syslogSDValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogStructuredData renders metadata as a single RFC 5424
+// STRUCTURED-DATA element so sinks get the same geo/UA/query-string
+// enrichment as the stdout log line, returning "-" (NILVALUE) when there
+// is nothing to attach.
+func syslogStructuredData(metadata log.Fields) string {
+	if len(metadata) == 0 {
+		return "-"
+	}
+
+	var b strings.Builder
+	b.WriteString("[metadata")
+	for k, v := range metadata {
+		fmt.Fprintf(&b, ` %s="%s"`, syslogSDIDReplacer.Replace(k), syslogSDValueReplacer.Replace(fmt.Sprint(v)))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+func mustJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+var (
+	esEndpoint      = flag.String("elasticsearch-endpoint", "http://localhost:9200", "Elasticsearch endpoint for the elasticsearch output sink")
+	esIndex         = flag.String("elasticsearch-index", "csp-violations", "Elasticsearch index name for the elasticsearch output sink")
+	esTLSSkipVerify = flag.Bool("elasticsearch-tls-skip-verify", false, "Skip TLS certificate verification for the elasticsearch output sink")
+	esTLSCAFile     = flag.String("elasticsearch-tls-ca-file", "", "PEM CA bundle to trust for the elasticsearch output sink (default: system roots)")
+
+	lokiEndpoint      = flag.String("loki-endpoint", "http://localhost:3100", "Grafana Loki endpoint for the loki output sink")
+	lokiTLSSkipVerify = flag.Bool("loki-tls-skip-verify", false, "Skip TLS certificate verification for the loki output sink")
+	lokiTLSCAFile     = flag.String("loki-tls-ca-file", "", "PEM CA bundle to trust for the loki output sink (default: system roots)")
+
+	kafkaBrokers       = flag.String("kafka-brokers", "localhost:9092", "Comma-separated list of Kafka broker addresses for the kafka output sink")
+	kafkaTopic         = flag.String("kafka-topic", "csp-violations", "Kafka topic for the kafka output sink")
+	kafkaTLSSkipVerify = flag.Bool("kafka-tls-skip-verify", false, "Skip TLS certificate verification for the kafka output sink")
+	kafkaTLSCAFile     = flag.String("kafka-tls-ca-file", "", "PEM CA bundle to trust for the kafka output sink (default: system roots)")
+
+	syslogAddr          = flag.String("syslog-address", "localhost:514", "Syslog server address for the syslog output sink")
+	syslogNetwork       = flag.String("syslog-network", "udp", "Transport for the syslog output sink: udp, tcp, or tcp-tls")
+	syslogTLSSkipVerify = flag.Bool("syslog-tls-skip-verify", false, "Skip TLS certificate verification for the syslog output sink (tcp-tls only)")
+	syslogTLSCAFile     = flag.String("syslog-tls-ca-file", "", "PEM CA bundle to trust for the syslog output sink (tcp-tls only, default: system roots)")
+)
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMergeMetadataOverlaysEnrichment(t *testing.T) {
+	body := CSPReportBody{DocumentURI: "https://example.com", BlockedURI: "https://evil.example.com"}
+	metadata := log.Fields{"geo_country": "US", "ua_browser": "Firefox"}
+
+	doc, err := mergeMetadata(body, metadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if doc["document-uri"] != "https://example.com" {
+		t.Errorf("expected report body field to survive the merge, got %v", doc["document-uri"])
+	}
+	if doc["geo_country"] != "US" {
+		t.Errorf("expected geo_country to be folded in, got %v", doc["geo_country"])
+	}
+	if doc["ua_browser"] != "Firefox" {
+		t.Errorf("expected ua_browser to be folded in, got %v", doc["ua_browser"])
+	}
+}
+
+func TestSyslogStructuredData(t *testing.T) {
+	if got := syslogStructuredData(log.Fields{}); got != "-" {
+		t.Errorf("expected NILVALUE for empty metadata, got %q", got)
+	}
+
+	got := syslogStructuredData(log.Fields{"geo_country": "US"})
+	if !strings.HasPrefix(got, "[metadata ") || !strings.HasSuffix(got, "]") {
+		t.Fatalf("expected a single bracketed SD-ELEMENT, got %q", got)
+	}
+	if !strings.Contains(got, `geo_country="US"`) {
+		t.Errorf("expected geo_country param in %q", got)
+	}
+}
+
+func TestSyslogStructuredDataEscapesSpecialCharacters(t *testing.T) {
+	got := syslogStructuredData(log.Fields{`weird key]`: `a "quoted" \value`})
+	if !strings.Contains(got, `weird_key_="a \"quoted\" \\value"`) {
+		t.Errorf("expected key and value to be sanitized/escaped, got %q", got)
+	}
+}
+
+func TestNewSinkDispatcherRejectsUnknownSink(t *testing.T) {
+	if _, err := newSinkDispatcher("not-a-real-sink", false); err == nil {
+		t.Fatal("expected an error for an unknown output sink")
+	}
+}
+
+func TestNewSinkDispatcherRejectsMissingTLSCAFile(t *testing.T) {
+	original := *esTLSCAFile
+	*esTLSCAFile = "/no/such/ca.pem"
+	defer func() { *esTLSCAFile = original }()
+
+	if _, err := newSinkDispatcher("elasticsearch", false); err == nil {
+		t.Fatal("expected an error for a missing elasticsearch TLS CA file")
+	}
+}
+
+func TestElasticsearchSinkWriteFoldsMetadata(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sink := &elasticsearchSink{endpoint: server.URL, index: "csp-violations", client: server.Client()}
+
+	report := CSPReport{Body: CSPReportBody{DocumentURI: "https://example.com", BlockedURI: "https://evil.example.com"}}
+	metadata := log.Fields{"geo_country": "US"}
+
+	if err := sink.Write(context.Background(), report, metadata); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(string(received), `"geo_country":"US"`) {
+		t.Errorf("expected bulk request body to contain folded metadata, got %q", received)
+	}
+}
+
+func TestElasticsearchSinkWriteReturnsErrorOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	sink := &elasticsearchSink{endpoint: server.URL, index: "csp-violations", client: server.Client()}
+
+	report := CSPReport{Body: CSPReportBody{DocumentURI: "https://example.com"}}
+	if err := sink.Write(context.Background(), report, nil); err == nil {
+		t.Fatal("expected an error when elasticsearch responds with a failure status")
+	}
+}
+
+func TestLokiSinkWriteFoldsMetadata(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := &lokiSink{endpoint: server.URL, client: server.Client()}
+
+	report := CSPReport{Body: CSPReportBody{DocumentURI: "https://example.com"}}
+	metadata := log.Fields{"ua_browser": "Firefox"}
+
+	if err := sink.Write(context.Background(), report, metadata); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(received, &payload); err != nil {
+		t.Fatalf("failed to decode push payload: %v", err)
+	}
+
+	streams := payload["streams"].([]interface{})
+	values := streams[0].(map[string]interface{})["values"].([]interface{})
+	line := values[0].([]interface{})[1].(string)
+
+	if !strings.Contains(line, `"ua_browser":"Firefox"`) {
+		t.Errorf("expected log line to contain folded metadata, got %q", line)
+	}
+}
+
+func TestSyslogSinkWriteIncludesStructuredData(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	sink := &syslogSink{network: "udp", addr: conn.LocalAddr().String()}
+
+	report := CSPReport{Body: CSPReportBody{DocumentURI: "https://example.com"}}
+	metadata := log.Fields{"geo_country": "US"}
+
+	if err := sink.Write(context.Background(), report, metadata); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("failed to read syslog message: %v", err)
+	}
+
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<30>1 ") {
+		t.Errorf("expected an RFC 5424 header with daemon.info priority, got %q", msg)
+	}
+	if !strings.Contains(msg, `[metadata geo_country="US"]`) {
+		t.Errorf("expected structured data to carry metadata, got %q", msg)
+	}
+}